@@ -0,0 +1,92 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+var errMethodNotAllowed = errors.New("method not allowed")
+
+// checkpointRequestBody is the (optional) JSON body accepted by
+// HandleCheckpoint. The set of containers to checkpoint is always the
+// starter's own live arangod/arangosync/agent containers (via
+// CheckpointManager.source), not something the caller supplies.
+type checkpointRequestBody struct {
+	CheckpointDir string `json:"checkpointDir,omitempty"` // Optional override for where dockerd stores the checkpoint.
+}
+
+// RegisterRoutes registers the `/local/checkpoint` and `/local/restore`
+// endpoints on mux, alongside the starter's other `/local/*` maintenance
+// endpoints (e.g. `/local/database-auto-upgrade`).
+func (m *CheckpointManager) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/local/checkpoint", m.HandleCheckpoint)
+	mux.HandleFunc("/local/restore", m.HandleRestore)
+}
+
+// HandleCheckpoint implements the `/local/checkpoint` API: it enumerates the
+// starter's currently running arangod/arangosync/agent containers and
+// checkpoints all of them, persisting the resulting manifest into the data
+// dir so a later call to HandleRestore can restore them.
+//
+// This mirrors the `/database-auto-upgrade`-style one-shot maintenance
+// endpoints: it is meant for a development cluster that needs a fast
+// warm-restart, not as a replacement for a full backup/restore story.
+func (m *CheckpointManager) HandleCheckpoint(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+		return
+	}
+	var body checkpointRequestBody
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	targets, err := m.source.CheckpointTargets()
+	if err != nil {
+		m.log.Errorf("Failed to enumerate checkpoint targets: %#v", err)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := m.Checkpoint(targets, CheckpointOptions{CheckpointDir: body.CheckpointDir}); err != nil {
+		m.log.Errorf("Checkpoint failed: %#v", err)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleRestore implements the `/local/restore` API: it restores the
+// starter's currently running containers from the manifest written by a
+// prior HandleCheckpoint call, in the reverse of the order they were
+// quiesced in.
+func (m *CheckpointManager) HandleRestore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+		return
+	}
+
+	targets, err := m.source.CheckpointTargets()
+	if err != nil {
+		m.log.Errorf("Failed to enumerate checkpoint targets: %#v", err)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := m.Restore(targets); err != nil {
+		m.log.Errorf("Restore failed: %#v", err)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// writeError writes a JSON error response, matching the starter's other
+// `/local/*` endpoints.
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,397 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/juju/errgo"
+	logging "github.com/op/go-logging"
+)
+
+const (
+	checkpointManifestFileName = "checkpoints.json"
+)
+
+// CheckpointOptions configures a single Checkpoint call.
+type CheckpointOptions struct {
+	CheckpointDir string // Optional directory (on the docker host) to store the checkpoint in, instead of dockerd's default.
+}
+
+// Checkpointer is an optional interface implemented by Process implementations
+// that support CRIU-based checkpoint/restore of their container.
+type Checkpointer interface {
+	// Checkpoint creates a checkpoint with the given name of the current state of the container.
+	Checkpoint(name string, opts CheckpointOptions) error
+	// Restore starts the container from the checkpoint with the given name.
+	Restore(name string) error
+	// ImageDigest returns the image (ID or digest) the container was created from,
+	// recorded in the checkpoint manifest so Restore can detect a stale checkpoint.
+	ImageDigest() string
+}
+
+// Quiescer is an optional interface implemented by Checkpointer targets that
+// can pause their own write traffic before being checkpointed, so the
+// resulting checkpoint reflects a consistent state. CheckpointManager calls
+// Quiesce immediately before checkpointing a target and Resume immediately
+// after, in the same agents-last order the starter already uses to shut
+// containers down.
+type Quiescer interface {
+	Quiesce() error
+	Resume() error
+}
+
+// Checkpoint creates a checkpoint of the container using the Docker daemon's
+// experimental checkpoint API (POST /containers/{id}/checkpoints). It
+// degrades gracefully with a clear error when the daemon does not have
+// experimental checkpoint support enabled.
+func (p *dockerContainer) Checkpoint(name string, opts CheckpointOptions) error {
+	body := map[string]interface{}{
+		"CheckpointID": name,
+	}
+	if opts.CheckpointDir != "" {
+		body["CheckpointDir"] = opts.CheckpointDir
+	}
+	path := fmt.Sprintf("/containers/%s/checkpoints", p.container.ID)
+	if err := dockerExperimentalPost(p.client, path, body); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// Restore starts the container from the given checkpoint, using the Docker
+// daemon's experimental checkpoint API
+// (POST /containers/{id}/start?checkpoint=<name>).
+func (p *dockerContainer) Restore(name string) error {
+	path := fmt.Sprintf("/containers/%s/start?checkpoint=%s", p.container.ID, url.QueryEscape(name))
+	if err := dockerExperimentalPost(p.client, path, nil); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// ImageDigest returns the ID of the image the container was created from, as
+// recorded by the docker daemon when the container was created.
+func (p *dockerContainer) ImageDigest() string {
+	return p.container.Image
+}
+
+// Quiesce pauses the container's process (via the cgroup freezer) so it stops
+// making further writes, giving CheckpointManager a consistent point to
+// checkpoint from. It implements Quiescer.
+func (p *dockerContainer) Quiesce() error {
+	if err := p.client.PauseContainer(p.container.ID); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// Resume unpauses a container previously paused by Quiesce. It implements Quiescer.
+func (p *dockerContainer) Resume() error {
+	if err := p.client.UnpauseContainer(p.container.ID); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// dockerExperimentalPost issues a raw POST request against the docker daemon
+// that client is connected to. It exists because go-dockerclient has no
+// native support for the (experimental) checkpoint endpoints.
+func dockerExperimentalPost(client *docker.Client, path string, body interface{}) error {
+	httpClient, scheme, err := rawDockerHTTPClient(client)
+	if err != nil {
+		return maskAny(err)
+	}
+
+	var reader *bytes.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return maskAny(err)
+		}
+		reader = bytes.NewReader(encoded)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequest("POST", scheme+"://docker"+path, reader)
+	if err != nil {
+		return maskAny(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return maskAny(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		if isExperimentalNotSupported(resp.StatusCode, respBody) {
+			return maskAny(fmt.Errorf("docker daemon does not support experimental checkpoint/restore: %s", string(respBody)))
+		}
+		return maskAny(fmt.Errorf("docker daemon returned status %d: %s", resp.StatusCode, string(respBody)))
+	}
+	return nil
+}
+
+// isExperimentalNotSupported returns true if the given response looks like
+// the daemon rejecting the (experimental) checkpoint/restore API because it
+// lacks experimental mode or CRIU support. A daemon with experimental mode
+// off typically answers 501, but one with experimental mode on yet no CRIU
+// binary installed answers 404 or 500 instead, so the status code alone is
+// not enough to tell apart from an unrelated failure.
+func isExperimentalNotSupported(status int, body []byte) bool {
+	if status == http.StatusNotImplemented {
+		return true
+	}
+	if status != http.StatusNotFound && status != http.StatusInternalServerError {
+		return false
+	}
+	lower := strings.ToLower(string(body))
+	for _, marker := range []string{"checkpoint", "criu", "experimental"} {
+		if strings.Contains(lower, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// rawDockerHTTPClient builds an *http.Client that talks to the same docker
+// endpoint the given client is connected to, for the few daemon APIs that
+// go-dockerclient does not expose. It reuses client.TLSConfig (set up by
+// createDockerClient from --docker.tls.*/DOCKER_* env vars) so that
+// checkpoint/restore against a TLS-protected remote daemon works the same way
+// the rest of dockerRunner does; it returns the URL scheme to use alongside
+// the client.
+func rawDockerHTTPClient(client *docker.Client) (*http.Client, string, error) {
+	endpoint := client.Endpoint()
+	proto, addr := "tcp", endpoint
+	switch {
+	case strings.HasPrefix(endpoint, "unix://"):
+		proto, addr = "unix", strings.TrimPrefix(endpoint, "unix://")
+	case strings.HasPrefix(endpoint, "tcp://"):
+		addr = strings.TrimPrefix(endpoint, "tcp://")
+	}
+
+	tlsConfig := client.TLSConfig
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			if tlsConfig != nil && proto != "unix" {
+				return tls.Dial(proto, addr, tlsConfig)
+			}
+			return net.Dial(proto, addr)
+		},
+	}
+	scheme := "http"
+	if tlsConfig != nil && proto != "unix" {
+		scheme = "https"
+	}
+	return &http.Client{Transport: transport, Timeout: time.Minute}, scheme, nil
+}
+
+// checkpointManifestEntry records where a single container's checkpoint was stored.
+type checkpointManifestEntry struct {
+	ContainerName  string `json:"container_name"`
+	CheckpointName string `json:"checkpoint_name"`
+	ImageDigest    string `json:"image_digest"`
+}
+
+// checkpointManifest is persisted into the data dir so that Restore can find
+// the checkpoints created by a prior Checkpoint call.
+type checkpointManifest struct {
+	Entries []checkpointManifestEntry `json:"entries"`
+}
+
+// checkpointTarget pairs a name (as used in the manifest and logging) with the
+// Checkpointer that backs it, and whether it is an agent (agents are
+// quiesced last on checkpoint, and so must be restored first).
+type checkpointTarget struct {
+	Name    string
+	Process Checkpointer
+	IsAgent bool
+}
+
+// checkpointContainerSource enumerates the containers currently eligible for
+// checkpoint/restore. dockerRunner implements it over its own tracked
+// containers; tests substitute a fake.
+type checkpointContainerSource interface {
+	CheckpointTargets() ([]checkpointTarget, error)
+}
+
+// CheckpointTargets returns the starter's currently tracked containers as
+// checkpoint targets. Container roles are not tracked explicitly elsewhere in
+// the starter yet, so until that lands, a container is treated as an agent
+// when its name contains "agent" (the naming convention used for the agency
+// containers the starter creates).
+func (r *dockerRunner) CheckpointTargets() ([]checkpointTarget, error) {
+	r.mutex.Lock()
+	ids := make([]string, 0, len(r.containerIDs))
+	for id := range r.containerIDs {
+		ids = append(ids, id)
+	}
+	r.mutex.Unlock()
+
+	targets := make([]checkpointTarget, 0, len(ids))
+	for _, id := range ids {
+		c, err := r.client.InspectContainer(id)
+		if err != nil {
+			if isNoSuchContainer(err) {
+				continue
+			}
+			return nil, maskAny(err)
+		}
+		name := strings.TrimPrefix(c.Name, "/")
+		targets = append(targets, checkpointTarget{
+			Name:    name,
+			Process: &dockerContainer{client: r.client, container: c},
+			IsAgent: strings.Contains(strings.ToLower(name), "agent"),
+		})
+	}
+	return targets, nil
+}
+
+// CheckpointManager coordinates checkpointing and restoring the running
+// arangod/arangosync/agent containers tracked by a dockerRunner, using
+// CRIU-based checkpoints of the underlying Docker containers.
+type CheckpointManager struct {
+	log     *logging.Logger
+	dataDir string
+	source  checkpointContainerSource
+}
+
+// NewCheckpointManager creates a CheckpointManager that enumerates containers
+// through source and persists its manifest into dataDir.
+func NewCheckpointManager(log *logging.Logger, dataDir string, source checkpointContainerSource) *CheckpointManager {
+	return &CheckpointManager{log: log, dataDir: dataDir, source: source}
+}
+
+// Checkpoint checkpoints all given targets, quiescing each one immediately
+// before it is checkpointed (agents last, so they keep serving the rest of
+// the cluster for as long as possible) and resuming it again right after, and
+// persists a manifest recording each container's checkpoint name and image
+// digest.
+func (m *CheckpointManager) Checkpoint(targets []checkpointTarget, opts CheckpointOptions) error {
+	ordered := orderForShutdown(targets)
+
+	manifest := checkpointManifest{}
+	checkpointName := fmt.Sprintf("starter-%d", time.Now().UnixNano())
+	for _, t := range ordered {
+		if q, ok := t.Process.(Quiescer); ok {
+			if err := q.Quiesce(); err != nil {
+				return maskAny(errgo.Notef(err, "failed to quiesce %s", t.Name))
+			}
+		}
+
+		m.log.Infof("Checkpointing %s", t.Name)
+		checkpointErr := t.Process.Checkpoint(checkpointName, opts)
+
+		if q, ok := t.Process.(Quiescer); ok {
+			if err := q.Resume(); err != nil {
+				m.log.Warningf("Failed to resume %s after checkpoint: %#v", t.Name, err)
+			}
+		}
+
+		if checkpointErr != nil {
+			return maskAny(errgo.Notef(checkpointErr, "failed to checkpoint %s", t.Name))
+		}
+		manifest.Entries = append(manifest.Entries, checkpointManifestEntry{
+			ContainerName:  t.Name,
+			CheckpointName: checkpointName,
+			ImageDigest:    t.Process.ImageDigest(),
+		})
+	}
+	return maskAny(m.writeManifest(manifest))
+}
+
+// Restore restores each of the given targets from the checkpoint recorded for
+// it in the manifest, in the reverse of the order they were quiesced in on
+// Checkpoint (agents first). It fails on the first target that has no
+// recorded checkpoint; manifest entries that match none of the targets are
+// not restored.
+func (m *CheckpointManager) Restore(targets []checkpointTarget) error {
+	manifest, err := m.readManifest()
+	if err != nil {
+		return maskAny(err)
+	}
+
+	ordered := orderForShutdown(targets)
+	for i := len(ordered) - 1; i >= 0; i-- {
+		t := ordered[i]
+		entry := findManifestEntry(manifest, t.Name)
+		if entry == nil {
+			return maskAny(fmt.Errorf("no checkpoint recorded for %s", t.Name))
+		}
+		if liveDigest := t.Process.ImageDigest(); entry.ImageDigest != "" && liveDigest != entry.ImageDigest {
+			return maskAny(fmt.Errorf("checkpoint for %s was taken from image %s, but %s is now running image %s", t.Name, entry.ImageDigest, t.Name, liveDigest))
+		}
+		m.log.Infof("Restoring %s from checkpoint %s", t.Name, entry.CheckpointName)
+		if err := t.Process.Restore(entry.CheckpointName); err != nil {
+			return maskAny(errgo.Notef(err, "failed to restore %s", t.Name))
+		}
+	}
+	return nil
+}
+
+// orderForShutdown returns targets ordered the way the starter shuts
+// containers down: non-agents first, agents last.
+func orderForShutdown(targets []checkpointTarget) []checkpointTarget {
+	var others, agents []checkpointTarget
+	for _, t := range targets {
+		if t.IsAgent {
+			agents = append(agents, t)
+		} else {
+			others = append(others, t)
+		}
+	}
+	return append(others, agents...)
+}
+
+// findManifestEntry looks up the manifest entry for the given container name.
+func findManifestEntry(manifest checkpointManifest, containerName string) *checkpointManifestEntry {
+	for i, e := range manifest.Entries {
+		if e.ContainerName == containerName {
+			return &manifest.Entries[i]
+		}
+	}
+	return nil
+}
+
+func (m *CheckpointManager) manifestPath() string {
+	return filepath.Join(m.dataDir, checkpointManifestFileName)
+}
+
+func (m *CheckpointManager) writeManifest(manifest checkpointManifest) error {
+	encoded, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return maskAny(err)
+	}
+	return maskAny(ioutil.WriteFile(m.manifestPath(), encoded, 0644))
+}
+
+func (m *CheckpointManager) readManifest() (checkpointManifest, error) {
+	var manifest checkpointManifest
+	raw, err := ioutil.ReadFile(m.manifestPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return manifest, maskAny(fmt.Errorf("no checkpoint manifest found in %s", m.dataDir))
+		}
+		return manifest, maskAny(err)
+	}
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return manifest, maskAny(err)
+	}
+	return manifest, nil
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// PullPolicy controls when dockerRunner.Start pulls the arangod image before
+// creating a container from it.
+type PullPolicy string
+
+const (
+	// PullPolicyAlways always pulls the image before starting a container.
+	PullPolicyAlways PullPolicy = "always"
+	// PullPolicyIfNotPresent only pulls the image when it is not already present locally.
+	PullPolicyIfNotPresent PullPolicy = "ifnotpresent"
+	// PullPolicyNever never pulls the image; it must already be present locally.
+	PullPolicyNever PullPolicy = "never"
+
+	// defaultRegistryServerAddress is the registry host used for images that
+	// do not name an explicit registry (e.g. "arangodb/arangodb").
+	defaultRegistryServerAddress = "https://index.docker.io/v1/"
+)
+
+// DockerImagePullConfig groups the image pull policy and registry credentials
+// used by dockerRunner when pulling the arangod image.
+type DockerImagePullConfig struct {
+	Policy        PullPolicy // When to pull the image. Defaults to PullPolicyAlways.
+	Username      string     // Explicit registry username (overrides ~/.docker/config.json)
+	Password      string     // Explicit registry password
+	ServerAddress string     // Explicit registry server address (used together with Username/Password)
+}
+
+// dockerImageClient is the subset of *docker.Client needed to pull images.
+// It exists so unit tests can substitute a mock docker client.
+type dockerImageClient interface {
+	InspectImage(name string) (*docker.Image, error)
+	PullImage(opts docker.PullImageOptions, auth docker.AuthConfiguration) error
+}
+
+// pullImageIfNeeded pulls the given image, honoring the configured pull policy
+// and resolving registry credentials for it.
+func pullImageIfNeeded(client dockerImageClient, image string, cfg DockerImagePullConfig) error {
+	if cfg.Policy == PullPolicyNever {
+		return nil
+	}
+	if cfg.Policy == PullPolicyIfNotPresent {
+		if _, err := client.InspectImage(image); err == nil {
+			// Image is already present, no need to pull.
+			return nil
+		} else if err != docker.ErrNoSuchImage {
+			return maskAny(err)
+		}
+	}
+
+	repo, tag := docker.ParseRepositoryTag(image)
+	auth := resolveAuthConfiguration(image, cfg)
+	if err := client.PullImage(docker.PullImageOptions{
+		Repository: repo,
+		Tag:        tag,
+	}, auth); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// dockerCfgAuthsLookup resolves the registry credentials recorded in
+// ~/.docker/config.json. It is a variable (rather than a direct call to
+// docker.NewAuthConfigurationsFromDockerCfg) so tests can substitute a fake
+// set of credentials instead of depending on the real file on disk.
+var dockerCfgAuthsLookup = docker.NewAuthConfigurationsFromDockerCfg
+
+// resolveAuthConfiguration builds the docker.AuthConfiguration to use when
+// pulling the given image: explicit credentials take precedence, otherwise
+// the registry's entry in ~/.docker/config.json (if any) is used. Credentials
+// registered for one registry host are never sent to a different host.
+func resolveAuthConfiguration(image string, cfg DockerImagePullConfig) docker.AuthConfiguration {
+	if cfg.Username != "" {
+		return docker.AuthConfiguration{
+			Username:      cfg.Username,
+			Password:      cfg.Password,
+			ServerAddress: cfg.ServerAddress,
+		}
+	}
+
+	auths, err := dockerCfgAuthsLookup()
+	if err != nil {
+		return docker.AuthConfiguration{}
+	}
+	if auth, ok := auths.Configs[registryHostForImage(image)]; ok {
+		return auth
+	}
+	return docker.AuthConfiguration{}
+}
+
+// registryHostForImage returns the registry host that the given image
+// reference is hosted on, defaulting to the Docker Hub registry address used
+// in ~/.docker/config.json when the image does not name an explicit registry.
+func registryHostForImage(image string) string {
+	repo, _ := docker.ParseRepositoryTag(image)
+	parts := strings.SplitN(repo, "/", 2)
+	if len(parts) == 2 && (strings.Contains(parts[0], ".") || strings.Contains(parts[0], ":") || parts[0] == "localhost") {
+		return parts[0]
+	}
+	return defaultRegistryServerAddress
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// fakeImageClient is a minimal dockerImageClient used to verify pull policy
+// and authentication behavior without talking to a real docker daemon.
+type fakeImageClient struct {
+	present    bool
+	pullCalled bool
+	authUsed   docker.AuthConfiguration
+}
+
+func (f *fakeImageClient) InspectImage(name string) (*docker.Image, error) {
+	if f.present {
+		return &docker.Image{}, nil
+	}
+	return nil, docker.ErrNoSuchImage
+}
+
+func (f *fakeImageClient) PullImage(opts docker.PullImageOptions, auth docker.AuthConfiguration) error {
+	f.pullCalled = true
+	f.authUsed = auth
+	return nil
+}
+
+func TestPullImageIfNeededPolicyNever(t *testing.T) {
+	client := &fakeImageClient{present: false}
+	if err := pullImageIfNeeded(client, "arangodb/arangodb", DockerImagePullConfig{Policy: PullPolicyNever}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if client.pullCalled {
+		t.Error("expected PullImage not to be called for PullPolicyNever")
+	}
+}
+
+func TestPullImageIfNeededPolicyIfNotPresentSkipsWhenCached(t *testing.T) {
+	client := &fakeImageClient{present: true}
+	if err := pullImageIfNeeded(client, "arangodb/arangodb", DockerImagePullConfig{Policy: PullPolicyIfNotPresent}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if client.pullCalled {
+		t.Error("expected PullImage not to be called when the image is already present")
+	}
+}
+
+func TestPullImageIfNeededPolicyIfNotPresentPullsWhenMissing(t *testing.T) {
+	client := &fakeImageClient{present: false}
+	if err := pullImageIfNeeded(client, "arangodb/arangodb", DockerImagePullConfig{Policy: PullPolicyIfNotPresent}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !client.pullCalled {
+		t.Error("expected PullImage to be called when the image is missing")
+	}
+}
+
+func TestPullImageIfNeededSendsExplicitCredentialsForImage(t *testing.T) {
+	client := &fakeImageClient{present: false}
+	cfg := DockerImagePullConfig{
+		Policy:        PullPolicyAlways,
+		Username:      "user",
+		Password:      "pass",
+		ServerAddress: "my-registry.example.com",
+	}
+	if err := pullImageIfNeeded(client, "my-registry.example.com/arangodb/arangodb", cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if client.authUsed.Username != "user" || client.authUsed.Password != "pass" {
+		t.Errorf("expected explicit credentials to be sent, got %+v", client.authUsed)
+	}
+}
+
+// withDockerCfgAuths temporarily replaces dockerCfgAuthsLookup with one that
+// returns the given configs, so tests do not depend on (or flake on) whatever
+// ~/.docker/config.json happens to exist on the host running the test.
+func withDockerCfgAuths(t *testing.T, configs map[string]docker.AuthConfiguration) {
+	original := dockerCfgAuthsLookup
+	dockerCfgAuthsLookup = func() (*docker.AuthConfigurations, error) {
+		return &docker.AuthConfigurations{Configs: configs}, nil
+	}
+	t.Cleanup(func() { dockerCfgAuthsLookup = original })
+}
+
+func TestPullImageIfNeededSendsNoAuthWithoutCredentials(t *testing.T) {
+	withDockerCfgAuths(t, nil)
+	client := &fakeImageClient{present: false}
+	if err := pullImageIfNeeded(client, "arangodb/arangodb", DockerImagePullConfig{Policy: PullPolicyAlways}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if client.authUsed != (docker.AuthConfiguration{}) {
+		t.Errorf("expected no credentials to be sent, got %+v", client.authUsed)
+	}
+}
+
+func TestPullImageIfNeededOnlySendsCredentialsForMatchingRegistryHost(t *testing.T) {
+	withDockerCfgAuths(t, map[string]docker.AuthConfiguration{
+		"registry-a.example.com": {Username: "user-a", Password: "pass-a"},
+	})
+	client := &fakeImageClient{present: false}
+	if err := pullImageIfNeeded(client, "registry-b.example.com/arangodb/arangodb", DockerImagePullConfig{Policy: PullPolicyAlways}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if client.authUsed != (docker.AuthConfiguration{}) {
+		t.Errorf("expected no credentials to be sent for a registry host with no matching config, got %+v", client.authUsed)
+	}
+}
+
+func TestRegistryHostForImage(t *testing.T) {
+	cases := map[string]string{
+		"arangodb/arangodb":                     defaultRegistryServerAddress,
+		"my-registry.example.com/arangodb":      "my-registry.example.com",
+		"localhost:5000/arangodb":               "localhost:5000",
+		"index.docker.io/arangodb/arangodb:3.3": "index.docker.io",
+	}
+	for image, expected := range cases {
+		if host := registryHostForImage(image); host != expected {
+			t.Errorf("registryHostForImage(%q) = %q, want %q", image, host, expected)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/cio"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/oci"
+	logging "github.com/op/go-logging"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/net/context"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	containerdNamespace = "arangodb-starter" // Namespace used for all tasks/containers created by the starter
+	containerdSocket    = "/run/containerd/containerd.sock"
+	// containerdLogDir is where stdout/stderr of tasks started by containerdRunner
+	// are captured to, since (unlike dockerd) containerd does not retain log
+	// output unless the caller asks for it explicitly.
+	containerdLogDir = "/var/log/arangodb-starter"
+)
+
+// NewContainerdRunner creates a runner that starts processes on the local OS
+// using containerd and an OCI runtime (runc) directly, without going through
+// the Docker daemon.
+func NewContainerdRunner(log *logging.Logger, endpoint, image, user, volumesFrom string, gcDelay time.Duration) (Runner, error) {
+	if endpoint == "" {
+		endpoint = containerdSocket
+	}
+	client, err := containerd.New(endpoint)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	return &containerdRunner{
+		log:         log,
+		client:      client,
+		image:       image,
+		user:        user,
+		volumesFrom: volumesFrom,
+		containers:  make(map[string]time.Time),
+		gcDelay:     gcDelay,
+	}, nil
+}
+
+// containerdRunner implements a Runner that starts processes in a containerd task,
+// running under an OCI runtime (runc), without requiring dockerd.
+type containerdRunner struct {
+	log         *logging.Logger
+	client      *containerd.Client
+	image       string
+	user        string
+	volumesFrom string
+	mutex       sync.Mutex
+	containers  map[string]time.Time
+	gcOnce      sync.Once
+	gcDelay     time.Duration
+}
+
+// containerdContainer implements Process for a task running under containerd.
+type containerdContainer struct {
+	client    *containerd.Client
+	container containerd.Container
+	task      containerd.Task
+}
+
+// nsContext returns a context bound to the starter's containerd namespace.
+func nsContext() context.Context {
+	return namespaces.WithNamespace(context.Background(), containerdNamespace)
+}
+
+func (r *containerdRunner) GetContainerDir(hostDir string) string {
+	if r.volumesFrom != "" {
+		return hostDir
+	}
+	return "/data"
+}
+
+func (r *containerdRunner) Start(command string, args []string, volumes []Volume, ports []int, containerName string) (Process, error) {
+	// Start gc (once)
+	r.gcOnce.Do(func() { go r.gc() })
+
+	ctx := nsContext()
+
+	r.log.Debugf("Pulling image %s", r.image)
+	image, err := r.client.Pull(ctx, r.image, containerd.WithPullUnpack)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+
+	containerName = strings.Replace(containerName, ":", "", -1)
+
+	var mounts []specs.Mount
+	if r.volumesFrom == "" {
+		for _, v := range volumes {
+			opts := []string{"rbind"}
+			if v.ReadOnly {
+				opts = append(opts, "ro")
+			} else {
+				opts = append(opts, "rw")
+			}
+			mounts = append(mounts, specs.Mount{
+				Destination: v.ContainerPath,
+				Source:      v.HostPath,
+				Type:        "bind",
+				Options:     opts,
+			})
+		}
+	}
+
+	// Note: containerd does not publish ports itself. The caller is expected
+	// to supply a CNI network configuration (or rely on host networking) for
+	// the requested ports to be reachable from outside the namespace.
+	if len(ports) > 0 {
+		r.log.Debugf("Requested ports %v will only be reachable if a CNI config or host networking is in place", ports)
+	}
+
+	specOpts := []oci.SpecOpts{
+		oci.WithImageConfig(image),
+		oci.WithProcessArgs(append([]string{command}, args...)...),
+		oci.WithMounts(mounts),
+	}
+	if r.user != "" {
+		specOpts = append(specOpts, oci.WithUser(r.user))
+	}
+
+	r.log.Debugf("Creating container %s", containerName)
+	container, err := r.client.NewContainer(
+		ctx,
+		containerName,
+		containerd.WithNewSnapshot(containerName+"-snapshot", image),
+		containerd.WithNewSpec(specOpts...),
+	)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	r.recordContainerID(containerName) // Record ID so we can clean it up later
+
+	r.log.Debugf("Starting task %s", containerName)
+	if err := os.MkdirAll(containerdLogDir, 0755); err != nil {
+		container.Delete(ctx)
+		return nil, maskAny(err)
+	}
+	logPath := filepath.Join(containerdLogDir, containerName+".log")
+	task, err := container.NewTask(ctx, cio.LogFile(logPath))
+	if err != nil {
+		container.Delete(ctx)
+		return nil, maskAny(err)
+	}
+	if err := task.Start(ctx); err != nil {
+		task.Delete(ctx)
+		container.Delete(ctx)
+		return nil, maskAny(err)
+	}
+	r.log.Debugf("Started task %s", containerName)
+
+	return &containerdContainer{
+		client:    r.client,
+		container: container,
+		task:      task,
+	}, nil
+}
+
+// CreateStartArangodbCommand returns an example `ctr` invocation that starts
+// this starter instance under containerd. Since containerd does not publish
+// ports itself, the example uses host networking (--net-host); in that mode
+// every instance on the same host must bind a distinct port, which is why
+// hostPort (unlike in the docker variant, where it is only used for the port
+// mapping) is passed into the starter itself via --ownPort.
+func (r *containerdRunner) CreateStartArangodbCommand(index int, masterIP string, masterPort string) string {
+	addr := masterIP
+	hostPort := 4000 + (portOffsetIncrement * (index - 1))
+	if masterPort != "" {
+		addr = addr + ":" + masterPort
+		masterPortI, _ := strconv.Atoi(masterPort)
+		hostPort = masterPortI + (portOffsetIncrement * (index - 1))
+	}
+	lines := []string{
+		fmt.Sprintf("mkdir -p arangodb%d &&", index),
+		fmt.Sprintf("ctr --namespace=%s run --rm --net-host", containerdNamespace),
+		fmt.Sprintf("--mount type=bind,src=$(pwd)/arangodb%d,dst=/data,options=rbind:rw", index),
+		fmt.Sprintf("docker.io/arangodb/arangodb-starter:latest adb%d", index),
+		fmt.Sprintf("arangodb --ownPort=%d --dockerContainer=adb%d --ownAddress=%s --join=%s", hostPort, index, masterIP, addr),
+	}
+	return strings.Join(lines, " \\\n    ")
+}
+
+// Cleanup after all processes are dead and have been cleaned themselves
+func (r *containerdRunner) Cleanup() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	ctx := nsContext()
+	for id := range r.containers {
+		r.log.Infof("Removing container %s", id)
+		if container, err := r.client.LoadContainer(ctx, id); err != nil {
+			if !isContainerdNotFound(err) {
+				r.log.Warningf("Failed to load container %s: %#v", id, err)
+			}
+			continue
+		} else {
+			if task, err := container.Task(ctx, nil); err == nil {
+				task.Delete(ctx, containerd.WithProcessKill)
+			}
+			if err := container.Delete(ctx, containerd.WithSnapshotCleanup); err != nil && !isContainerdNotFound(err) {
+				r.log.Warningf("Failed to remove container %s: %#v", id, err)
+			}
+		}
+	}
+	r.containers = nil
+
+	return nil
+}
+
+// recordContainerID records an ID of a created container
+func (r *containerdRunner) recordContainerID(id string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.containers[id] = time.Now()
+}
+
+// unrecordContainerID removes an ID from the list of created containers
+func (r *containerdRunner) unrecordContainerID(id string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.containers, id)
+}
+
+// gc performs continues garbage collection of stopped old tasks. Unlike
+// dockerRunner.gc, it does not rely solely on the in-memory set of
+// containers this runner created: it lists every container currently
+// registered in the starter's containerd namespace, so tasks created
+// out-of-band (e.g. directly with ctr, see CreateStartArangodbCommand) are
+// collected too, not just the ones this process happens to remember.
+func (r *containerdRunner) gc() {
+	ctx := nsContext()
+	for {
+		containers, err := r.client.Containers(ctx)
+		if err != nil {
+			r.log.Warningf("Failed to list containers in namespace %s: %#v", containerdNamespace, err)
+			time.Sleep(time.Minute)
+			continue
+		}
+		gcBoundary := time.Now().Add(-r.gcDelay)
+		for _, container := range containers {
+			id := container.ID()
+			info, err := container.Info(ctx)
+			if err != nil {
+				if isContainerdNotFound(err) {
+					r.unrecordContainerID(id)
+				}
+				continue
+			}
+			task, err := container.Task(ctx, nil)
+			if err != nil {
+				if isContainerdNotFound(err) && info.CreatedAt.Before(gcBoundary) {
+					if delErr := container.Delete(ctx, containerd.WithSnapshotCleanup); delErr != nil {
+						r.log.Warningf("Failed to remove container %s: %#v", id, delErr)
+					} else {
+						r.unrecordContainerID(id)
+					}
+				}
+				continue
+			}
+			status, err := task.Status(ctx)
+			if err != nil {
+				continue
+			}
+			stopped := status.Status == containerd.Stopped
+			old := info.CreatedAt.Before(gcBoundary)
+			if stopped && old {
+				r.log.Infof("Removing old container %s", id)
+				task.Delete(ctx, containerd.WithProcessKill)
+				if err := container.Delete(ctx, containerd.WithSnapshotCleanup); err != nil {
+					r.log.Warningf("Failed to remove container %s: %#v", id, err)
+				} else {
+					r.unrecordContainerID(id)
+				}
+			}
+		}
+		time.Sleep(time.Minute)
+	}
+}
+
+// ProcessID returns the pid of the process (if not running in docker)
+func (p *containerdContainer) ProcessID() int {
+	return 0
+}
+
+// ContainerID returns the ID of the containerd container that runs the process.
+func (p *containerdContainer) ContainerID() string {
+	return p.container.ID()
+}
+
+func (p *containerdContainer) Wait() {
+	ctx := nsContext()
+	statusC, err := p.task.Wait(ctx)
+	if err != nil {
+		return
+	}
+	<-statusC
+}
+
+func (p *containerdContainer) Terminate() error {
+	ctx := nsContext()
+	if err := p.task.Kill(ctx, unix.SIGTERM); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+func (p *containerdContainer) Kill() error {
+	ctx := nsContext()
+	if err := p.task.Kill(ctx, unix.SIGKILL); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+func (p *containerdContainer) Cleanup() error {
+	ctx := nsContext()
+	if _, err := p.task.Delete(ctx, containerd.WithProcessKill); err != nil {
+		return maskAny(err)
+	}
+	if err := p.container.Delete(ctx, containerd.WithSnapshotCleanup); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// isContainerdNotFound returns true if the given error indicates that the
+// container or task no longer exists.
+func isContainerdNotFound(err error) bool {
+	return errdefs.IsNotFound(err)
+}
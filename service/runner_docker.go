@@ -2,6 +2,7 @@ package service
 
 import (
 	"fmt"
+	"io/ioutil"
 	"strconv"
 	"strings"
 	"sync"
@@ -16,27 +17,84 @@ const (
 	stopContainerTimeout = 60 // Seconds before a container is killed (after graceful stop)
 )
 
+// DockerEndpoint holds the parameters needed to connect to a (possibly remote,
+// possibly TLS protected) Docker daemon.
+type DockerEndpoint struct {
+	Addr      string // Address of the docker daemon (e.g. unix:///var/run/docker.sock or tcp://1.2.3.4:2376)
+	FromEnv   bool   // If set, build the client from DOCKER_HOST/DOCKER_API_VERSION/DOCKER_TLS_VERIFY/DOCKER_CERT_PATH
+	TLSCACert string // Path to CA certificate used to verify the docker daemon
+	TLSCert   string // Path to client certificate used to authenticate to the docker daemon
+	TLSKey    string // Path to client key used to authenticate to the docker daemon
+}
+
 // NewDockerRunner creates a runner that starts processes on the local OS.
-func NewDockerRunner(log *logging.Logger, endpoint, image, user, volumesFrom string, gcDelay time.Duration) (Runner, error) {
-	client, err := docker.NewClient(endpoint)
+func NewDockerRunner(log *logging.Logger, endpoint DockerEndpoint, image, user, volumesFrom string, gcDelay time.Duration, imagePull DockerImagePullConfig) (Runner, error) {
+	client, err := createDockerClient(endpoint)
 	if err != nil {
-		return nil, maskAny(err)
+		return nil, maskAny(errgo.Notef(err, "failed to create docker client for endpoint '%s'", endpoint.Addr))
+	}
+	if imagePull.Policy == "" {
+		imagePull.Policy = PullPolicyAlways
 	}
 	return &dockerRunner{
 		log:          log,
 		client:       client,
+		imageClient:  client,
+		endpoint:     client.Endpoint(),
 		image:        image,
 		user:         user,
 		volumesFrom:  volumesFrom,
 		containerIDs: make(map[string]time.Time),
 		gcDelay:      gcDelay,
+		imagePull:    imagePull,
 	}, nil
 }
 
+// createDockerClient builds a docker client for the given endpoint, honoring
+// the standard DOCKER_HOST/DOCKER_API_VERSION/DOCKER_TLS_VERIFY/DOCKER_CERT_PATH
+// environment variables (when FromEnv is set or no explicit address was given)
+// and explicit TLS material otherwise.
+func createDockerClient(endpoint DockerEndpoint) (*docker.Client, error) {
+	switch {
+	case endpoint.FromEnv || endpoint.Addr == "":
+		return docker.NewClientFromEnv()
+	case endpoint.TLSCACert != "" || endpoint.TLSCert != "" || endpoint.TLSKey != "":
+		return newTLSDockerClient(endpoint)
+	default:
+		return docker.NewClient(endpoint.Addr)
+	}
+}
+
+// newTLSDockerClient builds a TLS-protected docker client. A client
+// certificate/key pair is only read when both TLSCert and TLSKey are given;
+// a CA certificate on its own (verify the daemon, no client authentication)
+// is a valid configuration, since it is exposed as its own flag.
+func newTLSDockerClient(endpoint DockerEndpoint) (*docker.Client, error) {
+	var certPEM, keyPEM, caPEM []byte
+	var err error
+	if endpoint.TLSCert != "" && endpoint.TLSKey != "" {
+		if certPEM, err = ioutil.ReadFile(endpoint.TLSCert); err != nil {
+			return nil, err
+		}
+		if keyPEM, err = ioutil.ReadFile(endpoint.TLSKey); err != nil {
+			return nil, err
+		}
+	}
+	if endpoint.TLSCACert != "" {
+		if caPEM, err = ioutil.ReadFile(endpoint.TLSCACert); err != nil {
+			return nil, err
+		}
+	}
+	return docker.NewVersionedTLSClientFromBytes(endpoint.Addr, certPEM, keyPEM, caPEM, "")
+}
+
 // dockerRunner implements a Runner that starts processes in a docker container.
 type dockerRunner struct {
 	log          *logging.Logger
 	client       *docker.Client
+	imageClient  dockerImageClient     // Narrow interface used for pulling images (see pullImageIfNeeded)
+	imagePull    DockerImagePullConfig // Pull policy and registry credentials
+	endpoint     string                // Effective docker endpoint, recorded for logging purposes
 	image        string
 	user         string
 	volumesFrom  string
@@ -62,14 +120,12 @@ func (r *dockerRunner) Start(command string, args []string, volumes []Volume, po
 	// Start gc (once)
 	r.gcOnce.Do(func() { go r.gc() })
 
-	// Pull docker image
-	repo, tag := docker.ParseRepositoryTag(r.image)
-	r.log.Debugf("Pulling image %s:%s", repo, tag)
-	if err := r.client.PullImage(docker.PullImageOptions{
-		Repository: repo,
-		Tag:        tag,
-	}, docker.AuthConfiguration{}); err != nil {
-		return nil, maskAny(err)
+	r.log.Debugf("Using docker endpoint %s", r.endpoint)
+
+	// Pull docker image, honoring the configured pull policy
+	r.log.Debugf("Pulling image %s (policy=%s)", r.image, r.imagePull.Policy)
+	if err := pullImageIfNeeded(r.imageClient, r.image, r.imagePull); err != nil {
+		return nil, maskAny(errgo.Notef(err, "failed to pull image '%s' via docker endpoint '%s'", r.image, r.endpoint))
 	}
 
 	containerName = strings.Replace(containerName, ":", "", -1)
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// interruptCount tracks how many termination signals have been received since
+// TrapSignals was installed. It is unexported but kept at package level so
+// tests can assert the bypass path deterministically.
+var interruptCount int32
+
+// TrapSignals installs handlers for SIGINT and SIGTERM (and, when DEBUG is set
+// in the environment, SIGQUIT) that coordinate the starter's shutdown:
+//   - 1st signal: runs cleanup exactly once, in a goroutine, and exits once it completes.
+//   - 2nd signal: logged and otherwise ignored, cleanup is already in progress.
+//   - 3rd signal: bypasses cleanup entirely and exits immediately, so that a
+//     cleanup stuck on a slow docker call (e.g. RemoveContainer) cannot wedge
+//     an operator's terminal.
+func TrapSignals(cleanup func()) {
+	signals := []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	if os.Getenv("DEBUG") != "" {
+		signals = append(signals, syscall.SIGQUIT)
+	}
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, signals...)
+
+	go func() {
+		for sig := range sigChan {
+			switch atomic.AddInt32(&interruptCount, 1) {
+			case 1:
+				go func() {
+					cleanup()
+					os.Exit(0)
+				}()
+			case 2:
+				fmt.Println("cleanup in progress, press ^C again to force")
+			default:
+				// Third (or later) signal: bypass cleanup entirely.
+				os.Exit(128 + int(sig.(syscall.Signal)))
+			}
+		}
+	}()
+}
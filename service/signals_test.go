@@ -0,0 +1,64 @@
+package service
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestTrapSignalsThirdSignalBypassesCleanup verifies that a third SIGINT
+// bypasses a cleanup function that never returns, by running TrapSignals in a
+// helper subprocess (the third signal calls os.Exit, which would otherwise
+// kill the test binary itself).
+func TestTrapSignalsThirdSignalBypassesCleanup(t *testing.T) {
+	cmd := exec.Command(os.Args[0], "-test.run=TestHelperTrapSignalsStuckCleanup")
+	cmd.Env = append(os.Environ(), "WANT_HELPER_PROCESS=1")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start helper process: %v", err)
+	}
+
+	// Give the helper time to install its signal handlers; the first SIGINT
+	// below is what makes it enter cleanup.
+	time.Sleep(200 * time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		if err := cmd.Process.Signal(syscall.SIGINT); err != nil {
+			t.Fatalf("failed to signal helper process: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case err := <-done:
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			t.Fatalf("expected helper process to exit with an error, got %v", err)
+		}
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			if code := status.ExitStatus(); code != 128+int(syscall.SIGINT) {
+				t.Errorf("expected exit code %d, got %d", 128+int(syscall.SIGINT), code)
+			}
+		}
+	case <-time.After(2 * time.Second):
+		cmd.Process.Kill()
+		t.Fatal("helper process did not exit after the third SIGINT; cleanup was not bypassed")
+	}
+}
+
+// TestHelperTrapSignalsStuckCleanup is not a real test; it is spawned as a
+// subprocess by TestTrapSignalsThirdSignalBypassesCleanup to exercise the
+// bypass path of TrapSignals without terminating the real test binary.
+func TestHelperTrapSignalsStuckCleanup(t *testing.T) {
+	if os.Getenv("WANT_HELPER_PROCESS") != "1" {
+		t.Skip("only runs as a helper subprocess")
+	}
+	TrapSignals(func() {
+		select {} // never returns, forcing the bypass path to be exercised
+	})
+	select {} // keep the process alive until a signal arrives
+}